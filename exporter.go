@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Exporter publishes a snapshot of fetched gym data somewhere other than the logs.
+type Exporter interface {
+	Publish(ctx context.Context, gyms []Gym) error
+}
+
+// ExporterConfig describes one exporter as it appears in CONFIG.
+type ExporterConfig struct {
+	Type    string   `json:"type"`
+	File    string   `json:"file,omitempty"`
+	URL     string   `json:"url,omitempty"`
+	Headers []Header `json:"headers,omitempty"`
+	Region  string   `json:"region,omitempty"`
+	Table   string   `json:"table,omitempty"`
+	Bucket  string   `json:"bucket,omitempty"`
+	Prefix  string   `json:"prefix,omitempty"`
+}
+
+// NewExporter builds the Exporter described by cfg.
+func NewExporter(cfg ExporterConfig) (Exporter, error) {
+	switch cfg.Type {
+	case "stdout":
+		return &jsonExporter{w: os.Stdout}, nil
+	case "file":
+		if cfg.File == "" {
+			return nil, fmt.Errorf("exporter %q: file path required", cfg.Type)
+		}
+		return &fileExporter{path: cfg.File}, nil
+	case "http":
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("exporter %q: url required", cfg.Type)
+		}
+		return &httpExporter{url: cfg.URL, headers: cfg.Headers}, nil
+	case "dynamodb":
+		if cfg.Table == "" {
+			return nil, fmt.Errorf("exporter %q: table required", cfg.Type)
+		}
+		sess, err := session.NewSession(&aws.Config{Region: aws.String(cfg.Region)})
+		if err != nil {
+			return nil, err
+		}
+		return &dynamoDBExporter{table: cfg.Table, svc: dynamodb.New(sess)}, nil
+	case "s3":
+		if cfg.Bucket == "" {
+			return nil, fmt.Errorf("exporter %q: bucket required", cfg.Type)
+		}
+		sess, err := session.NewSession(&aws.Config{Region: aws.String(cfg.Region)})
+		if err != nil {
+			return nil, err
+		}
+		return &s3Exporter{bucket: cfg.Bucket, prefix: cfg.Prefix, svc: s3.New(sess)}, nil
+	default:
+		return nil, fmt.Errorf("unknown exporter type %q", cfg.Type)
+	}
+}
+
+// jsonExporter writes one newline-delimited JSON array per Publish call to w.
+type jsonExporter struct {
+	w io.Writer
+}
+
+func (e *jsonExporter) Publish(ctx context.Context, gyms []Gym) error {
+	body, err := json.Marshal(gyms)
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(append(body, '\n'))
+	return err
+}
+
+// fileExporter appends one JSON array line per Publish call to a file on disk.
+type fileExporter struct {
+	path string
+}
+
+func (e *fileExporter) Publish(ctx context.Context, gyms []Gym) error {
+	f, err := os.OpenFile(e.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return (&jsonExporter{w: f}).Publish(ctx, gyms)
+}
+
+// httpExporter POSTs the gym snapshot as JSON to an arbitrary webhook.
+type httpExporter struct {
+	url     string
+	headers []Header
+}
+
+func (e *httpExporter) Publish(ctx context.Context, gyms []Gym) error {
+	body, err := json.Marshal(gyms)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for i := 0; i < len(e.headers); i++ {
+		req.Header.Set(e.headers[i].Key, e.headers[i].Value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%w: webhook returned %d", ErrUnexpectedReponse, resp.StatusCode)
+	}
+	return nil
+}
+
+// dynamoDBExporter writes one item per gym to a DynamoDB table.
+type dynamoDBExporter struct {
+	table string
+	svc   *dynamodb.DynamoDB
+}
+
+func (e *dynamoDBExporter) Publish(ctx context.Context, gyms []Gym) error {
+	for i := 0; i < len(gyms); i++ {
+		item, err := dynamodbattribute.MarshalMap(gyms[i])
+		if err != nil {
+			return err
+		}
+		_, err = e.svc.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+			TableName: aws.String(e.table),
+			Item:      item,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// s3Exporter uploads the whole gym snapshot as a single JSON object per Publish call.
+type s3Exporter struct {
+	bucket string
+	prefix string
+	svc    *s3.S3
+}
+
+func (e *s3Exporter) Publish(ctx context.Context, gyms []Gym) error {
+	body, err := json.Marshal(gyms)
+	if err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf("%s%d.json", e.prefix, time.Now().UnixNano())
+	_, err = e.svc.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(e.bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(body),
+	})
+	return err
+}
+
+// PublishAll fans gyms out to every exporter concurrently, returning the first error (if any)
+// once all exporters have finished.
+func PublishAll(ctx context.Context, exporters []Exporter, gyms []Gym) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(exporters))
+
+	for i := 0; i < len(exporters); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = exporters[i].Publish(ctx, gyms)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
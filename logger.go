@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"github.com/google/uuid"
+)
+
+// Logger wraps *slog.Logger so call sites in this package don't need to import log/slog
+// directly.
+type Logger struct {
+	*slog.Logger
+}
+
+// NewLogger builds a Logger whose handler is selected via LOG_FORMAT ("json" or "text",
+// defaulting to "text") and whose level is selected via LOG_LEVEL ("debug", "info", "warn",
+// "error", defaulting to "info").
+func NewLogger() *Logger {
+	opts := &slog.HandlerOptions{Level: parseLogLevel(os.Getenv("LOG_LEVEL"))}
+
+	var handler slog.Handler
+	if os.Getenv("LOG_FORMAT") == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	}
+
+	return &Logger{Logger: slog.New(handler)}
+}
+
+func parseLogLevel(level string) slog.Level {
+	switch level {
+	case "debug":
+		return slog.LevelDebug
+	case "warn":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// WithContext returns a Logger that attaches ctx's correlation ID (if any) to every log
+// line, so all logging for one endpoint fetch can be traced back to it.
+func (l *Logger) WithContext(ctx context.Context) *Logger {
+	id := CorrelationID(ctx)
+	if id == "" {
+		return l
+	}
+	return &Logger{Logger: l.Logger.With("correlation_id", id)}
+}
+
+type correlationIDKey struct{}
+
+// WithCorrelationID returns a context carrying a new correlation ID of the form
+// "<endpoint>-<uuid>", so logs for a single endpoint fetch can be grepped out together.
+func WithCorrelationID(ctx context.Context, endpoint string) context.Context {
+	return context.WithValue(ctx, correlationIDKey{}, endpoint+"-"+uuid.NewString())
+}
+
+// CorrelationID returns the correlation ID stored in ctx by WithCorrelationID, or "" if
+// none is set.
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationIDKey{}).(string)
+	return id
+}
@@ -5,17 +5,18 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
+	"math/rand"
 	"net/http"
 	"os"
-	"regexp"
+	"runtime"
 	"strings"
 	"sync"
 	"time"
 	"unicode"
 
 	"github.com/aws/aws-lambda-go/lambda"
-	"go.uber.org/zap"
+	"github.com/urfave/cli/v2"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 const DEFAULT_TIMEZONE = "Europe/London"
@@ -24,12 +25,7 @@ const CONFIG_FILENAME = "config.json"
 var ErrRegexMatchFailed error = errors.New("failed to match regex")
 var ErrUnexpectedReponse error = errors.New("received unexpected response from server")
 
-var timeRegex, _ = regexp.Compile(`(\d{1,2}):(\d{1,2}) (AM|PM)`)
-
-// var dataRegex, _ = regexp.Compile(`var\sdata\s=\s{\s\s+'.+'\s:\s({[^;]+}),\s+};`)
-var dataRegex, _ = regexp.Compile(`var\s+data\s+=\s+{([^;]+),\s+};`)
-
-var logger *zap.Logger
+var logger *Logger
 var client *http.Client
 
 type GymDataJSON struct {
@@ -64,6 +60,12 @@ type Endpoint struct {
 	Headers  []Header `json:"headers"`
 	Timezone string   `json:"timezone"`
 	Gyms     []Gym    `json:"gyms"`
+	// Scraper selects which registered Scraper fetches this endpoint's data. Defaults to
+	// DefaultScraper when empty.
+	Scraper string `json:"scraper,omitempty"`
+	// Query is an extraction expression interpreted by the chosen Scraper: a JMESPath
+	// expression for "json-api", a CSS selector for "html-css-selector", unused otherwise.
+	Query string `json:"query,omitempty"`
 }
 
 func StripWhitespace(str string) string {
@@ -77,91 +79,73 @@ func StripWhitespace(str string) string {
 	}, str)
 }
 
-func FetchGymData(endpoint Endpoint) (map[string]GymData, error) {
-	location, err := time.LoadLocation(endpoint.Timezone)
-	if err != nil {
-		return nil, err
-	}
+func FetchGymData(ctx context.Context, endpoint Endpoint) (map[string]GymData, error) {
+	ctx, span := tracer.Start(ctx, "FetchGymData")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("gym.brand", endpoint.Brand),
+		attribute.String("gym.endpoint", endpoint.Name),
+	)
 
-	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/portal/public/%s/occupancy", endpoint.URL, endpoint.ID), nil)
+	location, err := time.LoadLocation(endpoint.Timezone)
 	if err != nil {
+		span.RecordError(err)
 		return nil, err
 	}
-	for i := 0; i < len(endpoint.Headers); i++ {
-		req.Header.Set(endpoint.Headers[i].Key, endpoint.Headers[i].Value)
-	}
 
-	resp, err := client.Do(req)
+	scraper, err := ScraperFor(endpoint.Scraper)
 	if err != nil {
+		span.RecordError(err)
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, ErrUnexpectedReponse
-	}
-
-	body, err := io.ReadAll(resp.Body)
+	dataJSON, err := scraper.Fetch(ctx, endpoint)
 	if err != nil {
+		span.RecordError(err)
 		return nil, err
 	}
-
-	var dataJSON map[string]GymDataJSON
-
-	matches := dataRegex.FindStringSubmatch(string(body))
-	if len(matches) != 2 {
-		return nil, ErrRegexMatchFailed
-	}
-
-	fixedJSON := "{" + strings.Replace(matches[1], "'", `"`, -1) + "}"
-
-	if err := json.Unmarshal([]byte(fixedJSON), &dataJSON); err != nil {
-		return nil, err
-	}
-
-	now := time.Now()
+	span.SetAttributes(attribute.Int("gym.match_count", len(dataJSON)))
 
 	output := map[string]GymData{}
 	for k, v := range dataJSON {
-		parsedTime := timeRegex.FindStringSubmatch(v.LastUpdate)
-		if err != nil {
-			return nil, err
-		}
-		if len(parsedTime) != 4 {
-			return nil, ErrRegexMatchFailed
-		}
-
-		tempTime, err := time.ParseInLocation("3:04 PM", parsedTime[0], location)
+		lastUpdate, err := scraper.ParseLastUpdate(v.LastUpdate, location)
 		if err != nil {
+			span.RecordError(err)
 			return nil, err
 		}
 
 		output[k] = GymData{
 			Capacity:   v.Capacity,
 			Count:      v.Count,
-			LastUpdate: time.Date(now.Year(), now.Month(), now.Day(), tempTime.Hour(), tempTime.Minute(), 0, 0, time.UTC),
+			LastUpdate: lastUpdate,
 		}
 	}
 
 	return output, nil
 }
 
-func FetchEndpoint(e Endpoint) (*Endpoint, error) {
+func FetchEndpoint(ctx context.Context, e Endpoint, exporters []Exporter) (*Endpoint, error) {
+	log := logger.WithContext(ctx)
+
+	ctx, span := tracer.Start(ctx, "FetchEndpoint")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("gym.brand", e.Brand),
+		attribute.String("gym.endpoint", e.Name),
+	)
+
 	if e.Timezone == "" {
 		e.Timezone = DEFAULT_TIMEZONE
 	}
-	data, err := FetchGymData(e)
+	fetchesTotal.WithLabelValues(e.Name).Inc()
+	data, err := FetchGymData(ctx, e)
 	if err != nil {
-		logger.Error("get endpoint failed",
-			zap.Error(err),
-			zap.Reflect("endpoint", e),
-		)
+		fetchErrorsTotal.WithLabelValues(e.Name).Inc()
+		span.RecordError(err)
+		log.Error("get endpoint failed", "error", err, "endpoint", e.Name)
 		return nil, err
 	}
-	logger.Debug("got endpoint",
-		zap.Reflect("endpoint", e),
-		zap.Reflect("data", data),
-	)
+	log.Debug("got endpoint", "endpoint", e.Name, "data", data)
 
 	gymsMap := map[string]Gym{}
 	for i := 0; i < len(e.Gyms); i++ {
@@ -169,86 +153,236 @@ func FetchEndpoint(e Endpoint) (*Endpoint, error) {
 		gymsMap[e.Gyms[i].ShortCode] = e.Gyms[i]
 		if d, ok := data[e.Gyms[i].ShortCode]; ok {
 			e.Gyms[i].Data = d
-			logger.Info("got gym data",
-				zap.Any("gym", e.Gyms[i]),
-			)
+			log.Info("got gym data", "gym", e.Gyms[i])
+		}
+	}
+
+	if len(exporters) > 0 {
+		if err := PublishAll(ctx, exporters, e.Gyms); err != nil {
+			log.Error("publish failed", "error", err, "endpoint", e.Name)
 		}
 	}
 
 	return &e, nil
 }
 
-func FetchEndpointsFromConfig(c context.Context) error {
-	var endpoints []Endpoint
+// Config is the shape of the CONFIG env var / config.json file: the endpoints to poll and
+// the exporters to fan their results out to.
+type Config struct {
+	Endpoints []Endpoint       `json:"endpoints"`
+	Exporters []ExporterConfig `json:"exporters"`
+	// Concurrency caps how many endpoints are fetched at once. Defaults to
+	// min(len(Endpoints), runtime.NumCPU()*2) when unset.
+	Concurrency int `json:"concurrency,omitempty"`
+}
+
+func FetchEndpointsFromConfig(ctx context.Context) ([]Endpoint, error) {
+	ctx, span := tracer.Start(ctx, "FetchEndpointsFromConfig")
+	defer span.End()
+
+	var cfg Config
 
 	configRaw := []byte(os.Getenv("CONFIG"))
 	if len(configRaw) == 0 {
-		logger.Debug("getting config from file", zap.String("path", CONFIG_FILENAME))
+		logger.Debug("getting config from file", "path", CONFIG_FILENAME)
 		var err error
 		configRaw, err = os.ReadFile(CONFIG_FILENAME)
 		if err != nil {
-			logger.Fatal("could not read config", zap.String("path", CONFIG_FILENAME), zap.Error(err))
-			return err
+			logger.Error("could not read config", "path", CONFIG_FILENAME, "error", err)
+			return nil, err
 		}
-		logger.Debug("got config from file", zap.String("path", CONFIG_FILENAME), zap.String("raw_config", StripWhitespace(string(configRaw))))
+		logger.Debug("got config from file", "path", CONFIG_FILENAME, "raw_config", StripWhitespace(string(configRaw)))
 	} else {
-		logger.Debug("got config from env", zap.String("raw_config", StripWhitespace(string(configRaw))))
+		logger.Debug("got config from env", "raw_config", StripWhitespace(string(configRaw)))
 	}
 
-	if err := json.Unmarshal(configRaw, &endpoints); err != nil {
-		logger.Fatal("could not parse config", zap.Error(err))
-		return err
+	if err := json.Unmarshal(configRaw, &cfg); err != nil {
+		logger.Error("could not parse config", "error", err)
+		return nil, err
 	}
 
+	exporters := make([]Exporter, 0, len(cfg.Exporters))
+	for i := 0; i < len(cfg.Exporters); i++ {
+		exporter, err := NewExporter(cfg.Exporters[i])
+		if err != nil {
+			logger.Error("could not build exporter", "index", i, "error", err)
+			return nil, err
+		}
+		exporters = append(exporters, exporter)
+	}
+
+	span.SetAttributes(attribute.Int("gym.endpoint_count", len(cfg.Endpoints)))
+
+	concurrency := cfg.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency(len(cfg.Endpoints))
+	}
+
+	results := make([]Endpoint, len(cfg.Endpoints))
+	errs := make([]error, len(cfg.Endpoints))
+
+	sem := make(chan struct{}, concurrency)
 	var wg sync.WaitGroup
-	for i := 0; i < len(endpoints); i++ {
+	for i := 0; i < len(cfg.Endpoints); i++ {
 		wg.Add(1)
-		go func(e Endpoint) {
+		sem <- struct{}{}
+		go func(i int) {
 			defer wg.Done()
-			_, _ = FetchEndpoint(e)
-		}(endpoints[i])
-		wg.Wait()
+			defer func() { <-sem }()
+			e, err := fetchEndpointWithRetry(ctx, cfg.Endpoints[i], exporters)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			results[i] = *e
+		}(i)
 	}
-	return nil
+	wg.Wait()
+
+	return results, errors.Join(errs...)
 }
 
-func LambdaHandler(c context.Context) {
-	FetchEndpointsFromConfig(c)
+// defaultConcurrency caps fetch parallelism at min(n, runtime.NumCPU()*2), with a floor of 1.
+func defaultConcurrency(n int) int {
+	c := runtime.NumCPU() * 2
+	if n < c {
+		c = n
+	}
+	if c < 1 {
+		c = 1
+	}
+	return c
 }
 
-func init() {
-	loggerConfigRaw := []byte(os.Getenv("LOGGER_CONFIG"))
-	var cfg zap.Config
-	if len(loggerConfigRaw) != 0 {
-		if err := json.Unmarshal(loggerConfigRaw, &cfg); err != nil {
-			panic("could not parse logger config")
+const (
+	fetchTimeout   = 15 * time.Second
+	fetchAttempts  = 3
+	initialBackoff = 200 * time.Millisecond
+	maxBackoff     = 5 * time.Second
+)
+
+// fetchEndpointWithRetry calls FetchEndpoint with a per-attempt timeout derived from ctx,
+// retrying on failure with exponential backoff and jitter. A single correlation ID is
+// minted for the logical fetch and shared across every attempt, so retries of the same
+// endpoint can be grepped out together.
+func fetchEndpointWithRetry(ctx context.Context, e Endpoint, exporters []Exporter) (*Endpoint, error) {
+	ctx = WithCorrelationID(ctx, e.Name)
+	backoff := initialBackoff
+
+	var lastErr error
+	for attempt := 1; attempt <= fetchAttempts; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, fetchTimeout)
+		result, err := FetchEndpoint(attemptCtx, e, exporters)
+		cancel()
+		if err == nil {
+			return result, nil
 		}
-		var err error
-		logger, err = cfg.Build()
-		if err != nil {
-			panic("failed to initialise logger")
+		lastErr = err
+
+		if attempt == fetchAttempts {
+			break
 		}
-	} else {
-		var err error
-		logger, err = zap.NewDevelopment()
-		if err != nil {
-			panic("failed to initialise logger")
+
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff)))
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
 		}
 	}
 
-	client = &http.Client{}
+	return nil, fmt.Errorf("endpoint %q: %w", e.Name, lastErr)
+}
+
+func LambdaHandler(c context.Context) error {
+	_, err := FetchEndpointsFromConfig(c)
+	return err
+}
+
+func init() {
+	logger = NewLogger()
+	client = &http.Client{
+		Transport: &http.Transport{
+			MaxIdleConns:        100,
+			MaxIdleConnsPerHost: 10,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+	}
 }
 
 func LambdaMain() {
-	defer logger.Sync()
 	lambda.Start(LambdaHandler)
 }
 
 func main() {
-	defer logger.Sync()
-	if os.Getenv("AWS_EXECUTION_ENV") != "" {
-		lambda.Start(LambdaHandler)
+	shutdownTracing, err := InitTracing(context.Background())
+	if err != nil {
+		logger.Error("failed to initialise tracing", "error", err)
 	} else {
-		FetchEndpointsFromConfig()
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := shutdownTracing(ctx); err != nil {
+				logger.Error("failed to shut down tracing", "error", err)
+			}
+		}()
+	}
+
+	app := &cli.App{
+		Name:  "rgp-counter",
+		Usage: "fetch gym occupancy data and export it",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "mode",
+				Usage:   "lambda, server, or once; defaults based on AWS_EXECUTION_ENV",
+				EnvVars: []string{"MODE"},
+			},
+			&cli.IntFlag{
+				Name:    "port",
+				Usage:   "port to serve /metrics on in server mode",
+				Value:   8080,
+				EnvVars: []string{"PORT"},
+			},
+			&cli.DurationFlag{
+				Name:    "interval",
+				Usage:   "how often to re-fetch endpoints in server mode",
+				Value:   time.Minute,
+				EnvVars: []string{"INTERVAL"},
+			},
+		},
+		Action: func(c *cli.Context) error {
+			mode := c.String("mode")
+			if mode == "" {
+				if os.Getenv("AWS_EXECUTION_ENV") != "" {
+					mode = "lambda"
+				} else {
+					mode = "once"
+				}
+			}
+
+			switch mode {
+			case "lambda":
+				lambda.Start(LambdaHandler)
+				return nil
+			case "server":
+				return RunServer(c.Context, c.Int("port"), c.Duration("interval"))
+			case "once":
+				_, err := FetchEndpointsFromConfig(c.Context)
+				return err
+			default:
+				return fmt.Errorf("unknown mode %q", mode)
+			}
+		},
+	}
+
+	if err := app.Run(os.Args); err != nil {
+		logger.Error("exiting with error", "error", err)
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeScraper lets tests control Fetch's behaviour without hitting the network.
+type fakeScraper struct {
+	fetch func(ctx context.Context, endpoint Endpoint) (map[string]GymDataJSON, error)
+}
+
+func (f fakeScraper) Fetch(ctx context.Context, endpoint Endpoint) (map[string]GymDataJSON, error) {
+	return f.fetch(ctx, endpoint)
+}
+
+func (f fakeScraper) ParseLastUpdate(raw string, location *time.Location) (time.Time, error) {
+	return time.Now(), nil
+}
+
+// registerTestScraper registers s under name for the duration of the test.
+func registerTestScraper(t *testing.T, name string, s Scraper) {
+	t.Helper()
+	prev, had := scrapers[name]
+	scrapers[name] = s
+	t.Cleanup(func() {
+		if had {
+			scrapers[name] = prev
+		} else {
+			delete(scrapers, name)
+		}
+	})
+}
+
+// setConfigEnv marshals cfg into the CONFIG env var FetchEndpointsFromConfig reads from.
+func setConfigEnv(t *testing.T, cfg Config) {
+	t.Helper()
+	raw, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatalf("marshal config: %v", err)
+	}
+	t.Setenv("CONFIG", string(raw))
+}
+
+func TestFetchEndpointsFromConfig_BoundedConcurrency(t *testing.T) {
+	const concurrency = 2
+	const endpointCount = 6
+
+	var inFlight, maxInFlight int32
+	release := make(chan struct{})
+
+	registerTestScraper(t, "test-bounded", fakeScraper{
+		fetch: func(ctx context.Context, endpoint Endpoint) (map[string]GymDataJSON, error) {
+			n := atomic.AddInt32(&inFlight, 1)
+			for {
+				old := atomic.LoadInt32(&maxInFlight)
+				if n <= old || atomic.CompareAndSwapInt32(&maxInFlight, old, n) {
+					break
+				}
+			}
+			<-release
+			atomic.AddInt32(&inFlight, -1)
+			return map[string]GymDataJSON{}, nil
+		},
+	})
+
+	endpoints := make([]Endpoint, endpointCount)
+	for i := range endpoints {
+		endpoints[i] = Endpoint{Name: fmt.Sprintf("ep-%d", i), Scraper: "test-bounded"}
+	}
+	setConfigEnv(t, Config{Endpoints: endpoints, Concurrency: concurrency})
+
+	done := make(chan struct{})
+	go func() {
+		FetchEndpointsFromConfig(context.Background())
+		close(done)
+	}()
+
+	// Give the first `concurrency` fetches a chance to start and block on release; the
+	// remaining endpoints wait for a semaphore slot before their goroutines are spawned.
+	time.Sleep(200 * time.Millisecond)
+	close(release)
+	<-done
+
+	if got := atomic.LoadInt32(&maxInFlight); got != concurrency {
+		t.Fatalf("max concurrent fetches = %d, want exactly %d", got, concurrency)
+	}
+}
+
+func TestFetchEndpointWithRetry_RetriesThenSucceeds(t *testing.T) {
+	var attempts int32
+	registerTestScraper(t, "test-retry", fakeScraper{
+		fetch: func(ctx context.Context, endpoint Endpoint) (map[string]GymDataJSON, error) {
+			if atomic.AddInt32(&attempts, 1) < 3 {
+				return nil, errors.New("transient failure")
+			}
+			return map[string]GymDataJSON{
+				"sc1": {Capacity: 10, Count: 4, LastUpdate: time.Now().Format(time.RFC3339)},
+			}, nil
+		},
+	})
+
+	e := Endpoint{Name: "flaky", Scraper: "test-retry", Gyms: []Gym{{ShortCode: "sc1"}}}
+
+	result, err := fetchEndpointWithRetry(context.Background(), e, nil)
+	if err != nil {
+		t.Fatalf("fetchEndpointWithRetry() error = %v, want nil", err)
+	}
+	if result == nil {
+		t.Fatal("fetchEndpointWithRetry() returned nil result")
+	}
+	if got := atomic.LoadInt32(&attempts); got != 3 {
+		t.Fatalf("attempts = %d, want 3", got)
+	}
+}
+
+func TestFetchEndpointsFromConfig_AggregatesErrors(t *testing.T) {
+	registerTestScraper(t, "test-always-fails", fakeScraper{
+		fetch: func(ctx context.Context, endpoint Endpoint) (map[string]GymDataJSON, error) {
+			return nil, fmt.Errorf("boom for %s", endpoint.Name)
+		},
+	})
+	registerTestScraper(t, "test-always-succeeds", fakeScraper{
+		fetch: func(ctx context.Context, endpoint Endpoint) (map[string]GymDataJSON, error) {
+			return map[string]GymDataJSON{}, nil
+		},
+	})
+
+	endpoints := []Endpoint{
+		{Name: "bad-1", Scraper: "test-always-fails"},
+		{Name: "bad-2", Scraper: "test-always-fails"},
+		{Name: "good-1", Scraper: "test-always-succeeds"},
+	}
+	setConfigEnv(t, Config{Endpoints: endpoints, Concurrency: 3})
+
+	results, err := FetchEndpointsFromConfig(context.Background())
+	if err == nil {
+		t.Fatal("FetchEndpointsFromConfig() error = nil, want an aggregated error")
+	}
+	if !strings.Contains(err.Error(), "bad-1") || !strings.Contains(err.Error(), "bad-2") {
+		t.Fatalf("aggregated error %q missing per-endpoint detail", err.Error())
+	}
+	if len(results) != len(endpoints) {
+		t.Fatalf("len(results) = %d, want %d", len(results), len(endpoints))
+	}
+}
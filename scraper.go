@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/jmespath/go-jmespath"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// DefaultScraper is used when an Endpoint doesn't set Scraper.
+const DefaultScraper = "legend-js-embed"
+
+// Scraper fetches the raw per-gym occupancy data an Endpoint exposes, before it's converted
+// into GymData (timezone resolved, etc). Each Scraper also knows how to parse the
+// GymDataJSON.LastUpdate strings its own Fetch produces, since that format is scraper-
+// specific (Legend's quirky "3:04 PM" embed vs. a generic API's ISO-8601/epoch timestamp).
+type Scraper interface {
+	Fetch(ctx context.Context, endpoint Endpoint) (map[string]GymDataJSON, error)
+	ParseLastUpdate(raw string, location *time.Location) (time.Time, error)
+}
+
+var scrapers = map[string]Scraper{
+	DefaultScraper:      legendJSEmbedScraper{},
+	"json-api":          jsonAPIScraper{},
+	"html-css-selector": cssSelectorScraper{},
+}
+
+// ScraperFor looks up the Scraper registered under name, falling back to DefaultScraper
+// when name is empty.
+func ScraperFor(name string) (Scraper, error) {
+	if name == "" {
+		name = DefaultScraper
+	}
+	s, ok := scrapers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown scraper %q", name)
+	}
+	return s, nil
+}
+
+var dataRegex, _ = regexp.Compile(`var\s+data\s+=\s+{([^;]+),\s+};`)
+
+// legendJSEmbedScraper extracts the `var data = {...}` JS object Legend embeds in its
+// occupancy page HTML. This is the original, and still most common, scraping method.
+type legendJSEmbedScraper struct{}
+
+func (legendJSEmbedScraper) Fetch(ctx context.Context, endpoint Endpoint) (map[string]GymDataJSON, error) {
+	ctx, span := tracer.Start(ctx, "legendJSEmbedScraper.Fetch")
+	defer span.End()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/portal/public/%s/occupancy", endpoint.URL, endpoint.ID), nil)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	for i := 0; i < len(endpoint.Headers); i++ {
+		req.Header.Set(endpoint.Headers[i].Key, endpoint.Headers[i].Value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+
+	if resp.StatusCode != http.StatusOK {
+		span.RecordError(ErrUnexpectedReponse)
+		return nil, ErrUnexpectedReponse
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("http.response_size_bytes", len(body)))
+
+	matches := dataRegex.FindStringSubmatch(string(body))
+	if len(matches) != 2 {
+		span.RecordError(ErrRegexMatchFailed)
+		return nil, ErrRegexMatchFailed
+	}
+
+	fixedJSON := "{" + strings.Replace(matches[1], "'", `"`, -1) + "}"
+
+	var dataJSON map[string]GymDataJSON
+	if err := json.Unmarshal([]byte(fixedJSON), &dataJSON); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	return dataJSON, nil
+}
+
+var timeRegex, _ = regexp.Compile(`(\d{1,2}):(\d{1,2}) (AM|PM)`)
+
+// ParseLastUpdate parses Legend's "3:04 PM"-style timestamp, which carries no date, and
+// returns today's date with that hour and minute in UTC; location's offset is not applied.
+func (legendJSEmbedScraper) ParseLastUpdate(raw string, location *time.Location) (time.Time, error) {
+	matches := timeRegex.FindStringSubmatch(raw)
+	if len(matches) != 4 {
+		return time.Time{}, ErrRegexMatchFailed
+	}
+
+	parsed, err := time.ParseInLocation("3:04 PM", matches[0], location)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	now := time.Now()
+	return time.Date(now.Year(), now.Month(), now.Day(), parsed.Hour(), parsed.Minute(), 0, 0, time.UTC), nil
+}
+
+// parseGenericLastUpdate parses the timestamp formats a well-behaved generic API or HTML
+// page is expected to emit: RFC3339, or Unix seconds.
+func parseGenericLastUpdate(raw string, location *time.Location) (time.Time, error) {
+	if t, err := time.ParseInLocation(time.RFC3339, raw, location); err == nil {
+		return t.UTC(), nil
+	}
+	if secs, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return time.Unix(secs, 0).UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("%w: unrecognised last_update format %q", ErrRegexMatchFailed, raw)
+}
+
+// jsonAPIScraper calls an arbitrary JSON API and extracts the gym data map from the
+// response using endpoint.Query as a JMESPath expression. An empty Query assumes the
+// response body is already shaped like map[string]GymDataJSON.
+type jsonAPIScraper struct{}
+
+func (jsonAPIScraper) Fetch(ctx context.Context, endpoint Endpoint) (map[string]GymDataJSON, error) {
+	ctx, span := tracer.Start(ctx, "jsonAPIScraper.Fetch")
+	defer span.End()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.URL, nil)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	for i := 0; i < len(endpoint.Headers); i++ {
+		req.Header.Set(endpoint.Headers[i].Key, endpoint.Headers[i].Value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+
+	if resp.StatusCode != http.StatusOK {
+		span.RecordError(ErrUnexpectedReponse)
+		return nil, ErrUnexpectedReponse
+	}
+
+	var raw interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	if endpoint.Query != "" {
+		raw, err = jmespath.Search(endpoint.Query, raw)
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+	}
+
+	extracted, err := json.Marshal(raw)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	var dataJSON map[string]GymDataJSON
+	if err := json.Unmarshal(extracted, &dataJSON); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	return dataJSON, nil
+}
+
+// ParseLastUpdate parses the RFC3339 or Unix-seconds timestamp a JSON API is expected to
+// return.
+func (jsonAPIScraper) ParseLastUpdate(raw string, location *time.Location) (time.Time, error) {
+	return parseGenericLastUpdate(raw, location)
+}
+
+// cssSelectorScraper scrapes a plain HTML page by matching endpoint.Query (a CSS selector)
+// against elements that each describe one gym via data-shortcode/data-capacity/data-count/
+// data-last-update attributes.
+type cssSelectorScraper struct{}
+
+func (cssSelectorScraper) Fetch(ctx context.Context, endpoint Endpoint) (map[string]GymDataJSON, error) {
+	ctx, span := tracer.Start(ctx, "cssSelectorScraper.Fetch")
+	defer span.End()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.URL, nil)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	for i := 0; i < len(endpoint.Headers); i++ {
+		req.Header.Set(endpoint.Headers[i].Key, endpoint.Headers[i].Value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+
+	if resp.StatusCode != http.StatusOK {
+		span.RecordError(ErrUnexpectedReponse)
+		return nil, ErrUnexpectedReponse
+	}
+
+	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	selector := endpoint.Query
+	if selector == "" {
+		selector = "[data-shortcode]"
+	}
+
+	dataJSON := map[string]GymDataJSON{}
+	doc.Find(selector).Each(func(_ int, s *goquery.Selection) {
+		shortcode, ok := s.Attr("data-shortcode")
+		if !ok {
+			return
+		}
+		capacity, _ := strconv.Atoi(s.AttrOr("data-capacity", "0"))
+		count, _ := strconv.Atoi(s.AttrOr("data-count", "0"))
+		dataJSON[shortcode] = GymDataJSON{
+			Capacity:   capacity,
+			Count:      count,
+			LastUpdate: s.AttrOr("data-last-update", ""),
+		}
+	})
+
+	return dataJSON, nil
+}
+
+// ParseLastUpdate parses the RFC3339 or Unix-seconds timestamp carried in the
+// data-last-update attribute.
+func (cssSelectorScraper) ParseLastUpdate(raw string, location *time.Location) (time.Time, error) {
+	return parseGenericLastUpdate(raw, location)
+}
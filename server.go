@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	gymCount = promauto.With(metricsRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gym_count",
+		Help: "Current occupancy count reported by the gym endpoint.",
+	}, []string{"brand", "location", "shortcode"})
+
+	gymCapacity = promauto.With(metricsRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gym_capacity",
+		Help: "Maximum occupancy capacity reported by the gym endpoint.",
+	}, []string{"brand", "location", "shortcode"})
+
+	gymLastUpdateTimestamp = promauto.With(metricsRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gym_last_update_timestamp_seconds",
+		Help: "Unix timestamp of the last occupancy update reported by the gym endpoint.",
+	}, []string{"brand", "location", "shortcode"})
+
+	scrapesTotal = promauto.With(metricsRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "rgp_counter_scrapes_total",
+		Help: "Number of times the endpoint config has been fetched in server mode.",
+	})
+
+	fetchesTotal = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "rgp_counter_fetches_total",
+		Help: "Number of fetch attempts per endpoint.",
+	}, []string{"endpoint"})
+
+	fetchErrorsTotal = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "rgp_counter_fetch_errors_total",
+		Help: "Number of failed fetch attempts per endpoint.",
+	}, []string{"endpoint"})
+)
+
+// RunServer runs an HTTP server exposing Prometheus metrics on /metrics, re-fetching the
+// configured endpoints every interval to keep the gauges fresh. It blocks until the server
+// stops or ctx is cancelled.
+func RunServer(ctx context.Context, port int, interval time.Duration) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%d", port),
+		Handler: mux,
+	}
+
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	go refreshMetricsLoop(ctx, interval)
+
+	logger.Info("starting metrics server", "port", port, "interval", interval)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func refreshMetricsLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		refreshMetrics(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func refreshMetrics(ctx context.Context) {
+	scrapesTotal.Inc()
+
+	endpoints, err := FetchEndpointsFromConfig(ctx)
+	if err != nil {
+		logger.Error("one or more endpoints failed to refresh", "error", err)
+	}
+
+	for i := 0; i < len(endpoints); i++ {
+		for j := 0; j < len(endpoints[i].Gyms); j++ {
+			gym := endpoints[i].Gyms[j]
+			labels := prometheus.Labels{
+				"brand":     gym.Brand,
+				"location":  gym.Location,
+				"shortcode": gym.ShortCode,
+			}
+			gymCount.With(labels).Set(float64(gym.Data.Count))
+			gymCapacity.With(labels).Set(float64(gym.Data.Capacity))
+			gymLastUpdateTimestamp.With(labels).Set(float64(gym.Data.LastUpdate.Unix()))
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+)
+
+const tracerName = "github.com/csb/rgp-counter/v2"
+
+var tracer = otel.Tracer(tracerName)
+
+func noopShutdown(context.Context) error { return nil }
+
+// InitTracing wires up an OTLP/gRPC trace exporter, configured via the standard
+// OTEL_EXPORTER_OTLP_ENDPOINT (and friends) env vars, and installs it as the global
+// TracerProvider. It returns a shutdown func that must be called before the process exits
+// to flush any buffered spans. If no OTLP endpoint is configured, tracing is left disabled
+// (a no-op shutdown is returned) rather than spending the process's exit blocking on a
+// collector that was never going to be there.
+func InitTracing(ctx context.Context) (func(context.Context) error, error) {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return noopShutdown, nil
+	}
+
+	exporter, err := otlptracegrpc.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		semconv.ServiceNameKey.String("rgp-counter"),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+
+	return tp.Shutdown, nil
+}